@@ -0,0 +1,33 @@
+// Copyright 2021 RetailNext, Inc. All rights reserved.
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package agekms provides a pluggable abstraction over multiple Key
+// Management Service backends (Google Cloud KMS, AWS KMS and local PEM
+// files in this module's gcpkms, awskms and softkms packages; others can
+// be added with Register), letting identities and recipients be
+// constructed uniformly from a key URI rather than tying call sites to a
+// single provider's SDK.
+package agekms
+
+import "filippo.io/age"
+
+// KeyManager is implemented by each supported KMS backend. Backends
+// register themselves under a URI scheme with Register; NewClient
+// dispatches to one based on the scheme of the key URI it is given.
+//
+// CreateDecrypter and CreateRecipient each take the same kind of key URI
+// passed to NewClient, so a single KeyManager obtained from one key URI
+// can also be used to construct identities/recipients for other keys
+// reachable through the same backend (for example, other key versions in
+// the same GCP project).
+type KeyManager interface {
+	// CreateDecrypter returns an age.Identity that decrypts using the key
+	// identified by keyURI.
+	CreateDecrypter(keyURI string) (age.Identity, error)
+
+	// CreateRecipient returns an age.Recipient that encrypts to the key
+	// identified by keyURI.
+	CreateRecipient(keyURI string) (age.Recipient, error)
+}
@@ -0,0 +1,146 @@
+// Copyright 2021 RetailNext, Inc. All rights reserved.
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package awskms
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"filippo.io/age"
+
+	"github.com/retailnext/agekms/rsaoaep"
+)
+
+// NewClient creates an age.Identity that decrypts using AWS KMS.
+// Only RSA keys supporting the RSAES_OAEP_SHA_256 encryption algorithm
+// are supported. keyARNs may be key ARNs, key IDs, aliases, or
+// multi-region key ARNs; all forms are resolved to their canonical key
+// ARN via GetPublicKey.
+// All decrypt operations use the context provided to NewClient.
+func NewClient(ctx context.Context, keyARNs []string, optFns ...func(*kms.Options)) (Client, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cl := client{
+		kmsClient:  kms.NewFromConfig(cfg, optFns...),
+		ctx:        ctx,
+		arnByKeyID: make(map[string]string, len(keyARNs)),
+	}
+	for _, arn := range keyARNs {
+		if err := cl.addKey(ctx, arn); err != nil {
+			return nil, fmt.Errorf("problem with key %q: %w", arn, err)
+		}
+	}
+	return &cl, nil
+}
+
+// Client is an age.Identity; it holds no resources that need closing, but
+// implements Close to stay interchangeable with the other agekms backends.
+type Client interface {
+	Unwrap(stanzas []*age.Stanza) (fileKey []byte, err error)
+	Close() error
+}
+
+// kmsClient is the subset of *kms.Client used by client and keyManager,
+// narrowed so tests can supply a fake.
+type kmsClient interface {
+	GetPublicKey(ctx context.Context, params *kms.GetPublicKeyInput, optFns ...func(*kms.Options)) (*kms.GetPublicKeyOutput, error)
+	Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error)
+}
+
+type client struct {
+	kmsClient  kmsClient
+	ctx        context.Context
+	arnByKeyID map[string]string
+}
+
+// fetchRSAPublicKey retrieves and validates the RSA-OAEP public key for
+// keyARN, shared by addKey and the KeyManager's CreateRecipient. It
+// returns the resolved key ARN alongside the key.
+func fetchRSAPublicKey(ctx context.Context, kmsClient kmsClient, keyARN string) (*rsa.PublicKey, string, error) {
+	resp, err := kmsClient.GetPublicKey(ctx, &kms.GetPublicKeyInput{KeyId: aws.String(keyARN)})
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch resp.KeySpec {
+	case types.KeySpecRsa2048, types.KeySpecRsa3072, types.KeySpecRsa4096:
+	default:
+		return nil, "", fmt.Errorf("unsupported key type: %s", resp.KeySpec)
+	}
+
+	supported := false
+	for _, alg := range resp.EncryptionAlgorithms {
+		if alg == types.EncryptionAlgorithmSpecRsaesOaepSha256 {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return nil, "", fmt.Errorf("key %q does not support %s", keyARN, types.EncryptionAlgorithmSpecRsaesOaepSha256)
+	}
+
+	parsed, err := x509.ParsePKIXPublicKey(resp.PublicKey)
+	if err != nil {
+		return nil, "", err
+	}
+	key, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return nil, "", fmt.Errorf("unexpected public key type %T", parsed)
+	}
+	return key, aws.ToString(resp.KeyId), nil
+}
+
+func (c *client) addKey(ctx context.Context, keyARN string) error {
+	key, resolvedARN, err := fetchRSAPublicKey(ctx, c.kmsClient, keyARN)
+	if err != nil {
+		return err
+	}
+	id, err := rsaoaep.KeyID(key)
+	if err != nil {
+		return err
+	}
+	c.arnByKeyID[id] = resolvedARN
+	return nil
+}
+
+func (c *client) Unwrap(stanzas []*age.Stanza) (fileKey []byte, err error) {
+	for _, stanza := range stanzas {
+		if stanza.Type != "kms-rsa-oaep-sha256" {
+			continue
+		}
+		if len(stanza.Args) != 1 {
+			return nil, fmt.Errorf("invalid kms-rsa-oaep-sha256 recipient")
+		}
+
+		keyARN, ok := c.arnByKeyID[stanza.Args[0]]
+		if !ok {
+			continue
+		}
+
+		resp, err := c.kmsClient.Decrypt(c.ctx, &kms.DecryptInput{
+			KeyId:               aws.String(keyARN),
+			CiphertextBlob:      stanza.Body,
+			EncryptionAlgorithm: types.EncryptionAlgorithmSpecRsaesOaepSha256,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return resp.Plaintext, nil
+	}
+	return nil, age.ErrIncorrectIdentity
+}
+
+func (c *client) Close() error {
+	return nil
+}
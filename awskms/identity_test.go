@@ -0,0 +1,150 @@
+// Copyright 2021 RetailNext, Inc. All rights reserved.
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package awskms
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"filippo.io/age"
+)
+
+const testKeyARN = "arn:aws:kms:us-east-1:111122223333:key/test-key"
+
+var errTestDecrypt = errors.New("decrypt failed")
+
+// fakeKMSClient implements kmsClient for testing without calling out to
+// AWS KMS.
+type fakeKMSClient struct {
+	keySpec              types.KeySpec
+	encryptionAlgorithms []types.EncryptionAlgorithmSpec
+	publicKeyDER         []byte
+
+	decryptResp *kms.DecryptOutput
+	decryptErr  error
+}
+
+func (f *fakeKMSClient) GetPublicKey(ctx context.Context, params *kms.GetPublicKeyInput, optFns ...func(*kms.Options)) (*kms.GetPublicKeyOutput, error) {
+	return &kms.GetPublicKeyOutput{
+		KeyId:                params.KeyId,
+		KeySpec:              f.keySpec,
+		EncryptionAlgorithms: f.encryptionAlgorithms,
+		PublicKey:            f.publicKeyDER,
+	}, nil
+}
+
+func (f *fakeKMSClient) Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	return f.decryptResp, f.decryptErr
+}
+
+func newTestClient(t *testing.T, keySpec types.KeySpec, algs []types.EncryptionAlgorithmSpec) (*client, *rsa.PrivateKey) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey: %v", err)
+	}
+
+	fake := &fakeKMSClient{keySpec: keySpec, encryptionAlgorithms: algs, publicKeyDER: der}
+	cl := &client{kmsClient: fake, ctx: context.Background(), arnByKeyID: make(map[string]string)}
+	if err := cl.addKey(context.Background(), testKeyARN); err != nil {
+		t.Fatalf("addKey: %v", err)
+	}
+	return cl, key
+}
+
+func stanzaFor(cl *client) *age.Stanza {
+	for id := range cl.arnByKeyID {
+		return &age.Stanza{Type: "kms-rsa-oaep-sha256", Args: []string{id}, Body: []byte("ciphertext")}
+	}
+	return nil
+}
+
+func TestAddKeyRejectsUnsupportedKeySpec(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey: %v", err)
+	}
+
+	fake := &fakeKMSClient{
+		keySpec:              types.KeySpecEccNistP256,
+		encryptionAlgorithms: []types.EncryptionAlgorithmSpec{types.EncryptionAlgorithmSpecRsaesOaepSha256},
+		publicKeyDER:         der,
+	}
+	cl := &client{kmsClient: fake, ctx: context.Background(), arnByKeyID: make(map[string]string)}
+
+	if err := cl.addKey(context.Background(), testKeyARN); err == nil {
+		t.Fatal("expected error for unsupported key spec, got nil")
+	}
+}
+
+func TestAddKeyRejectsUnsupportedEncryptionAlgorithm(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey: %v", err)
+	}
+
+	fake := &fakeKMSClient{
+		keySpec:              types.KeySpecRsa2048,
+		encryptionAlgorithms: []types.EncryptionAlgorithmSpec{types.EncryptionAlgorithmSpecRsaesOaepSha1},
+		publicKeyDER:         der,
+	}
+	cl := &client{kmsClient: fake, ctx: context.Background(), arnByKeyID: make(map[string]string)}
+
+	if err := cl.addKey(context.Background(), testKeyARN); err == nil {
+		t.Fatal("expected error for unsupported encryption algorithm, got nil")
+	}
+}
+
+func TestUnwrapSucceeds(t *testing.T) {
+	cl, _ := newTestClient(t, types.KeySpecRsa2048, []types.EncryptionAlgorithmSpec{types.EncryptionAlgorithmSpecRsaesOaepSha256})
+	plaintext := []byte("file key material")
+	cl.kmsClient.(*fakeKMSClient).decryptResp = &kms.DecryptOutput{Plaintext: plaintext}
+
+	got, err := cl.Unwrap([]*age.Stanza{stanzaFor(cl)})
+	if err != nil {
+		t.Fatalf("Unwrap: %v", err)
+	}
+	if string(got) != string(plaintext) {
+		t.Fatalf("Unwrap returned %q, want %q", got, plaintext)
+	}
+}
+
+func TestUnwrapPropagatesDecryptError(t *testing.T) {
+	cl, _ := newTestClient(t, types.KeySpecRsa2048, []types.EncryptionAlgorithmSpec{types.EncryptionAlgorithmSpecRsaesOaepSha256})
+	cl.kmsClient.(*fakeKMSClient).decryptErr = errTestDecrypt
+
+	if _, err := cl.Unwrap([]*age.Stanza{stanzaFor(cl)}); err != errTestDecrypt {
+		t.Fatalf("Unwrap error = %v, want %v", err, errTestDecrypt)
+	}
+}
+
+func TestUnwrapSkipsStanzaForUnknownKeyID(t *testing.T) {
+	cl, _ := newTestClient(t, types.KeySpecRsa2048, []types.EncryptionAlgorithmSpec{types.EncryptionAlgorithmSpecRsaesOaepSha256})
+
+	stanza := &age.Stanza{Type: "kms-rsa-oaep-sha256", Args: []string{"not-a-registered-key-id"}, Body: []byte("ciphertext")}
+	if _, err := cl.Unwrap([]*age.Stanza{stanza}); err != age.ErrIncorrectIdentity {
+		t.Fatalf("Unwrap error = %v, want age.ErrIncorrectIdentity", err)
+	}
+}
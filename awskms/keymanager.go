@@ -0,0 +1,61 @@
+// Copyright 2021 RetailNext, Inc. All rights reserved.
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package awskms
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"filippo.io/age"
+
+	"github.com/retailnext/agekms"
+	"github.com/retailnext/agekms/rsaoaep"
+)
+
+func init() {
+	agekms.Register("awskms", newKeyManager)
+}
+
+// keyManager implements agekms.KeyManager for key URIs of the form
+// "awskms:<key ARN, key ID, alias, or multi-region key ARN>".
+type keyManager struct {
+	kmsClient kmsClient
+	ctx       context.Context
+}
+
+func newKeyManager(ctx context.Context) (agekms.KeyManager, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &keyManager{kmsClient: kms.NewFromConfig(cfg), ctx: ctx}, nil
+}
+
+func (m *keyManager) CreateDecrypter(keyURI string) (age.Identity, error) {
+	_, keyARN, err := agekms.SplitKeyURI(keyURI)
+	if err != nil {
+		return nil, err
+	}
+	cl := &client{kmsClient: m.kmsClient, ctx: m.ctx, arnByKeyID: make(map[string]string, 1)}
+	if err := cl.addKey(m.ctx, keyARN); err != nil {
+		return nil, fmt.Errorf("problem with key %q: %w", keyARN, err)
+	}
+	return cl, nil
+}
+
+func (m *keyManager) CreateRecipient(keyURI string) (age.Recipient, error) {
+	_, keyARN, err := agekms.SplitKeyURI(keyURI)
+	if err != nil {
+		return nil, err
+	}
+	key, _, err := fetchRSAPublicKey(m.ctx, m.kmsClient, keyARN)
+	if err != nil {
+		return nil, err
+	}
+	return rsaoaep.NewRecipient(key)
+}
@@ -0,0 +1,87 @@
+// Copyright 2021 RetailNext, Inc. All rights reserved.
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package awskms
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+func TestKeyManagerCreateRecipientAndCreateDecrypterRoundTrip(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey: %v", err)
+	}
+	fake := &fakeKMSClient{
+		keySpec:              types.KeySpecRsa2048,
+		encryptionAlgorithms: []types.EncryptionAlgorithmSpec{types.EncryptionAlgorithmSpecRsaesOaepSha256},
+		publicKeyDER:         der,
+	}
+	m := &keyManager{kmsClient: fake, ctx: context.Background()}
+	keyURI := "awskms:" + testKeyARN
+
+	recipient, err := m.CreateRecipient(keyURI)
+	if err != nil {
+		t.Fatalf("CreateRecipient: %v", err)
+	}
+	fileKey := []byte("file key material")
+	stanzas, err := recipient.Wrap(fileKey)
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+
+	fake.decryptResp = &kms.DecryptOutput{Plaintext: fileKey}
+	identity, err := m.CreateDecrypter(keyURI)
+	if err != nil {
+		t.Fatalf("CreateDecrypter: %v", err)
+	}
+	got, err := identity.Unwrap(stanzas)
+	if err != nil {
+		t.Fatalf("Unwrap: %v", err)
+	}
+	if string(got) != string(fileKey) {
+		t.Fatalf("Unwrap returned %q, want %q", got, fileKey)
+	}
+}
+
+func TestKeyManagerCreateDecrypterReusesKeyManagersClient(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey: %v", err)
+	}
+	fake := &fakeKMSClient{
+		keySpec:              types.KeySpecRsa2048,
+		encryptionAlgorithms: []types.EncryptionAlgorithmSpec{types.EncryptionAlgorithmSpecRsaesOaepSha256},
+		publicKeyDER:         der,
+	}
+	m := &keyManager{kmsClient: fake, ctx: context.Background()}
+
+	identity, err := m.CreateDecrypter("awskms:" + testKeyARN)
+	if err != nil {
+		t.Fatalf("CreateDecrypter: %v", err)
+	}
+	cl, ok := identity.(*client)
+	if !ok {
+		t.Fatalf("CreateDecrypter returned %T, want *client", identity)
+	}
+	if cl.kmsClient != fake {
+		t.Fatal("CreateDecrypter built a client around a different kmsClient than the KeyManager's own")
+	}
+}
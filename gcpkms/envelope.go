@@ -0,0 +1,170 @@
+// Copyright 2021 RetailNext, Inc. All rights reserved.
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gcpkms
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	kms "cloud.google.com/go/kms/apiv1"
+	"filippo.io/age"
+	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// DefaultEnvelopeDEKTTL is the data-key cache TTL used by the
+// "gcpkms-envelope" agekms.KeyManager scheme.
+const DefaultEnvelopeDEKTTL = 5 * time.Minute
+
+// NewEnvelopeRecipient creates an age.Recipient that wraps file keys
+// using envelope encryption: a locally generated AES-256 data key (DEK)
+// seals the file key with AES-GCM, and only the DEK itself is wrapped by
+// the symmetric Google Cloud KMS key named by name. The DEK is cached
+// in-process and reused across Wrap calls until ttl elapses, so a batch
+// of files costs one KMS round trip rather than one per file.
+func NewEnvelopeRecipient(ctx context.Context, name string, ttl time.Duration) (age.Recipient, error) {
+	kmsClient, err := kms.NewKeyManagementClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &envelopeRecipient{kmsClient: kmsClient, ctx: ctx, name: name, ttl: ttl}, nil
+}
+
+type envelopeRecipient struct {
+	kmsClient kmsClient
+	ctx       context.Context
+	name      string
+	ttl       time.Duration
+
+	mu         sync.Mutex
+	dek        []byte
+	wrappedDEK []byte
+	expiresAt  time.Time
+}
+
+// dataKey returns the recipient's cached AES-256 DEK and its KMS-wrapped
+// form, generating and wrapping a fresh one via KMS if none is cached or
+// the cached one has passed its TTL.
+func (r *envelopeRecipient) dataKey() (dek, wrappedDEK []byte, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.dek != nil && time.Now().Before(r.expiresAt) {
+		return r.dek, r.wrappedDEK, nil
+	}
+
+	dek = make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, nil, err
+	}
+
+	req := &kmspb.EncryptRequest{
+		Name:            r.name,
+		Plaintext:       dek,
+		PlaintextCrc32C: wrapperspb.Int64(int64(crc32c(dek))),
+	}
+	resp, err := r.kmsClient.Encrypt(r.ctx, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !resp.VerifiedPlaintextCrc32C {
+		return nil, nil, fmt.Errorf("kms: request PlaintextCrc32C was not verified by the server")
+	}
+	if int64(crc32c(resp.Ciphertext)) != resp.CiphertextCrc32C.Value {
+		return nil, nil, fmt.Errorf("kms: response corrupted in transit: ciphertext checksum mismatch")
+	}
+
+	r.dek, r.wrappedDEK = dek, resp.Ciphertext
+	r.expiresAt = time.Now().Add(r.ttl)
+	return r.dek, r.wrappedDEK, nil
+}
+
+func (r *envelopeRecipient) Wrap(fileKey []byte) ([]*age.Stanza, error) {
+	dek, wrappedDEK, err := r.dataKey()
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newAESGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealedFileKey := gcm.Seal(nil, nonce, fileKey, nil)
+
+	body := make([]byte, 0, 4+len(wrappedDEK)+len(sealedFileKey))
+	body = binary.BigEndian.AppendUint32(body, uint32(len(wrappedDEK)))
+	body = append(body, wrappedDEK...)
+	body = append(body, sealedFileKey...)
+
+	return []*age.Stanza{{
+		Type: "kms-envelope-aes256-gcm",
+		Args: []string{r.name, base64.StdEncoding.EncodeToString(nonce)},
+		Body: body,
+	}}, nil
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// unwrapEnvelope decrypts a "kms-envelope-aes256-gcm" stanza: it unwraps
+// the DEK via KMS using name, then opens the AES-GCM-sealed file key
+// locally using nonceB64 and body, which is the KMS-wrapped DEK prefixed
+// with its own big-endian uint32 length followed by the sealed file key.
+func unwrapEnvelope(ctx context.Context, kmsClient kmsClient, name, nonceB64 string, body []byte) ([]byte, error) {
+	nonce, err := base64.StdEncoding.DecodeString(nonceB64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kms-envelope-aes256-gcm nonce: %w", err)
+	}
+
+	if len(body) < 4 {
+		return nil, fmt.Errorf("invalid kms-envelope-aes256-gcm body")
+	}
+	wrappedLen := binary.BigEndian.Uint32(body[:4])
+	rest := body[4:]
+	if uint64(len(rest)) < uint64(wrappedLen) {
+		return nil, fmt.Errorf("invalid kms-envelope-aes256-gcm body")
+	}
+	wrappedDEK, sealedFileKey := rest[:wrappedLen], rest[wrappedLen:]
+
+	req := &kmspb.DecryptRequest{
+		Name:             name,
+		Ciphertext:       wrappedDEK,
+		CiphertextCrc32C: wrapperspb.Int64(int64(crc32c(wrappedDEK))),
+	}
+	resp, err := kmsClient.Decrypt(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	// DecryptResponse has no VerifiedCiphertextCrc32C field (unlike
+	// AsymmetricDecryptResponse); KMS already rejects a corrupted request
+	// ciphertext server-side, via the error above, so only the returned
+	// plaintext (the unwrapped DEK) needs checking here.
+	if int64(crc32c(resp.Plaintext)) != resp.PlaintextCrc32C.Value {
+		return nil, fmt.Errorf("kms: response corrupted in transit: plaintext checksum mismatch")
+	}
+
+	gcm, err := newAESGCM(resp.Plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, nonce, sealedFileKey, nil)
+}
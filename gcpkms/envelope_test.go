@@ -0,0 +1,204 @@
+// Copyright 2021 RetailNext, Inc. All rights reserved.
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gcpkms
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+)
+
+const testEnvelopeKeyName = "projects/p/locations/l/keyRings/r/cryptoKeys/k"
+
+func newCountingSymmetricKMS(tag string) (*fakeKMSClient, *int) {
+	fake := &fakeSymmetricKMS{tag: tag}
+	encryptCalls := 0
+	kc := &fakeKMSClient{
+		encryptFn: func(req *kmspb.EncryptRequest) (*kmspb.EncryptResponse, error) {
+			encryptCalls++
+			return fake.encrypt(req)
+		},
+		decryptSymmetricFn: fake.decrypt,
+	}
+	return kc, &encryptCalls
+}
+
+func TestEnvelopeWrapUnwrapRoundTrip(t *testing.T) {
+	kc, _ := newCountingSymmetricKMS("wrapped-dek:")
+	r := &envelopeRecipient{kmsClient: kc, ctx: context.Background(), name: testEnvelopeKeyName, ttl: time.Minute}
+
+	fileKey := []byte("file key material")
+	stanzas, err := r.Wrap(fileKey)
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+	if len(stanzas) != 1 {
+		t.Fatalf("got %d stanzas, want 1", len(stanzas))
+	}
+	stanza := stanzas[0]
+	if stanza.Type != "kms-envelope-aes256-gcm" {
+		t.Fatalf("stanza.Type = %q, want kms-envelope-aes256-gcm", stanza.Type)
+	}
+	if len(stanza.Args) != 2 || stanza.Args[0] != testEnvelopeKeyName {
+		t.Fatalf("stanza.Args = %v, want [%q, <nonce>]", stanza.Args, testEnvelopeKeyName)
+	}
+
+	got, err := unwrapEnvelope(context.Background(), kc, stanza.Args[0], stanza.Args[1], stanza.Body)
+	if err != nil {
+		t.Fatalf("unwrapEnvelope: %v", err)
+	}
+	if string(got) != string(fileKey) {
+		t.Fatalf("unwrapEnvelope returned %q, want %q", got, fileKey)
+	}
+}
+
+func TestEnvelopeWrapBodyIsLengthPrefixedWrappedDEK(t *testing.T) {
+	kc, _ := newCountingSymmetricKMS("wrapped-dek:")
+	r := &envelopeRecipient{kmsClient: kc, ctx: context.Background(), name: testEnvelopeKeyName, ttl: time.Minute}
+
+	stanzas, err := r.Wrap([]byte("file key material"))
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+	body := stanzas[0].Body
+
+	if len(body) < 4 {
+		t.Fatalf("body too short: %d bytes", len(body))
+	}
+	wrappedLen := binary.BigEndian.Uint32(body[:4])
+	rest := body[4:]
+	if uint64(len(rest)) < uint64(wrappedLen) {
+		t.Fatalf("declared wrapped DEK length %d exceeds remaining body %d", wrappedLen, len(rest))
+	}
+	wrappedDEK := rest[:wrappedLen]
+	if string(wrappedDEK[:len("wrapped-dek:")]) != "wrapped-dek:" {
+		t.Fatalf("wrapped DEK missing expected tag: %q", wrappedDEK)
+	}
+}
+
+func TestEnvelopeWrapReusesCachedDEKWithinTTL(t *testing.T) {
+	kc, encryptCalls := newCountingSymmetricKMS("wrapped-dek:")
+	r := &envelopeRecipient{kmsClient: kc, ctx: context.Background(), name: testEnvelopeKeyName, ttl: time.Minute}
+
+	if _, err := r.Wrap([]byte("file key one")); err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+	if _, err := r.Wrap([]byte("file key two")); err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+
+	if *encryptCalls != 1 {
+		t.Fatalf("Encrypt called %d times, want 1 (DEK should be cached within TTL)", *encryptCalls)
+	}
+}
+
+func TestEnvelopeWrapRewrapsDEKAfterTTLExpires(t *testing.T) {
+	kc, encryptCalls := newCountingSymmetricKMS("wrapped-dek:")
+	r := &envelopeRecipient{kmsClient: kc, ctx: context.Background(), name: testEnvelopeKeyName, ttl: time.Minute}
+
+	if _, err := r.Wrap([]byte("file key one")); err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+
+	r.mu.Lock()
+	r.expiresAt = time.Now().Add(-time.Second)
+	r.mu.Unlock()
+
+	if _, err := r.Wrap([]byte("file key two")); err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+
+	if *encryptCalls != 2 {
+		t.Fatalf("Encrypt called %d times, want 2 (DEK should be refreshed after TTL expiry)", *encryptCalls)
+	}
+}
+
+func TestEnvelopeWrapUsesDistinctNoncePerCall(t *testing.T) {
+	kc, _ := newCountingSymmetricKMS("wrapped-dek:")
+	r := &envelopeRecipient{kmsClient: kc, ctx: context.Background(), name: testEnvelopeKeyName, ttl: time.Minute}
+
+	s1, err := r.Wrap([]byte("file key material"))
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+	s2, err := r.Wrap([]byte("file key material"))
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+
+	if s1[0].Args[1] == s2[0].Args[1] {
+		t.Fatalf("expected distinct nonces across Wrap calls, got the same one twice: %q", s1[0].Args[1])
+	}
+}
+
+func TestUnwrapEnvelopeRejectsInvalidNonce(t *testing.T) {
+	kc, _ := newCountingSymmetricKMS("wrapped-dek:")
+	r := &envelopeRecipient{kmsClient: kc, ctx: context.Background(), name: testEnvelopeKeyName, ttl: time.Minute}
+
+	stanzas, err := r.Wrap([]byte("file key material"))
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+
+	if _, err := unwrapEnvelope(context.Background(), kc, testEnvelopeKeyName, "not-valid-base64!!", stanzas[0].Body); err == nil {
+		t.Fatal("expected error for invalid base64 nonce, got nil")
+	}
+}
+
+func TestUnwrapEnvelopeRejectsTamperedNonce(t *testing.T) {
+	kc, _ := newCountingSymmetricKMS("wrapped-dek:")
+	r := &envelopeRecipient{kmsClient: kc, ctx: context.Background(), name: testEnvelopeKeyName, ttl: time.Minute}
+
+	stanzas, err := r.Wrap([]byte("file key material"))
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+
+	wrongNonce := make([]byte, 12)
+	wrongNonce[0] = 0xff
+	tamperedNonceB64 := base64.StdEncoding.EncodeToString(wrongNonce)
+
+	if _, err := unwrapEnvelope(context.Background(), kc, testEnvelopeKeyName, tamperedNonceB64, stanzas[0].Body); err == nil {
+		t.Fatal("expected AES-GCM open to fail with a mismatched nonce, got nil")
+	}
+}
+
+func TestUnwrapEnvelopeRejectsShortBody(t *testing.T) {
+	kc, _ := newCountingSymmetricKMS("wrapped-dek:")
+
+	if _, err := unwrapEnvelope(context.Background(), kc, testEnvelopeKeyName, base64.StdEncoding.EncodeToString(make([]byte, 12)), []byte{0, 0}); err == nil {
+		t.Fatal("expected error for body shorter than the length prefix, got nil")
+	}
+}
+
+func TestUnwrapEnvelopeRejectsTruncatedWrappedDEK(t *testing.T) {
+	kc, _ := newCountingSymmetricKMS("wrapped-dek:")
+
+	body := make([]byte, 0, 4)
+	body = binary.BigEndian.AppendUint32(body, 100)
+	body = append(body, []byte("too short")...)
+
+	if _, err := unwrapEnvelope(context.Background(), kc, testEnvelopeKeyName, base64.StdEncoding.EncodeToString(make([]byte, 12)), body); err == nil {
+		t.Fatal("expected error when declared wrapped DEK length exceeds body, got nil")
+	}
+}
+
+func TestUnwrapEnvelopePropagatesDecryptError(t *testing.T) {
+	kc := &fakeKMSClient{decryptSymmetricFn: func(req *kmspb.DecryptRequest) (*kmspb.DecryptResponse, error) {
+		return nil, errTestDecrypt
+	}}
+
+	body := make([]byte, 0, 4)
+	body = binary.BigEndian.AppendUint32(body, 0)
+
+	if _, err := unwrapEnvelope(context.Background(), kc, testEnvelopeKeyName, base64.StdEncoding.EncodeToString(make([]byte, 12)), body); err != errTestDecrypt {
+		t.Fatalf("unwrapEnvelope error = %v, want %v", err, errTestDecrypt)
+	}
+}
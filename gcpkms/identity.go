@@ -8,31 +8,27 @@ package gcpkms
 import (
 	"context"
 	"crypto/rsa"
-	"crypto/sha256"
 	"crypto/x509"
-	"encoding/asn1"
-	"encoding/base64"
 	"encoding/pem"
 	"fmt"
 	"hash/crc32"
+	"strings"
 
 	kms "cloud.google.com/go/kms/apiv1"
 	"filippo.io/age"
+	gax "github.com/googleapis/gax-go/v2"
 	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
 	"google.golang.org/protobuf/types/known/wrapperspb"
-)
 
-func keyID(key *rsa.PublicKey) (string, error) {
-	keyBytes, err := asn1.Marshal(key)
-	if err != nil {
-		return "", err
-	}
-	digest := sha256.Sum256(keyBytes)
-	return base64.StdEncoding.EncodeToString(digest[:]), nil
-}
+	"github.com/retailnext/agekms/rsaoaep"
+)
 
 // NewClient creates an age.Identity that decrypts using Google Cloud KMS.
-// Only `RSA_DECRYPT_OAEP_*_SHA256` keys are supported.
+// names may be either `RSA_DECRYPT_OAEP_*_SHA256` CryptoKeyVersions
+// (decrypted via the "kms-rsa-oaep-sha256" stanza) or symmetric
+// ENCRYPT_DECRYPT-purpose CryptoKeys (decrypted via the
+// "kms-gcp-symmetric" and "kms-envelope-aes256-gcm" stanzas); NewClient
+// tells them apart by whether name points at a CryptoKeyVersion.
 // All decrypt operations use the context provided to NewClient.
 // The underlying KeyManagementClient retains connection resources until
 // Close is called on the Client.
@@ -42,9 +38,10 @@ func NewClient(ctx context.Context, names []string) (Client, error) {
 		return nil, err
 	}
 	cl := client{
-		kmsClient:   kmsClient,
-		ctx:         ctx,
-		nameByKeyID: make(map[string]string, len(names)),
+		kmsClient:      kmsClient,
+		ctx:            ctx,
+		nameByKeyID:    make(map[string]string, len(names)),
+		symmetricNames: make(map[string]bool),
 	}
 	for _, name := range names {
 		if err := cl.addKey(ctx, name); err != nil {
@@ -60,10 +57,26 @@ type Client interface {
 	Close() error
 }
 
+// kmsClient is the subset of *kms.KeyManagementClient used by client and
+// keyManager, narrowed so tests can supply a fake.
+type kmsClient interface {
+	GetPublicKey(ctx context.Context, req *kmspb.GetPublicKeyRequest, opts ...gax.CallOption) (*kmspb.PublicKey, error)
+	AsymmetricDecrypt(ctx context.Context, req *kmspb.AsymmetricDecryptRequest, opts ...gax.CallOption) (*kmspb.AsymmetricDecryptResponse, error)
+	Encrypt(ctx context.Context, req *kmspb.EncryptRequest, opts ...gax.CallOption) (*kmspb.EncryptResponse, error)
+	Decrypt(ctx context.Context, req *kmspb.DecryptRequest, opts ...gax.CallOption) (*kmspb.DecryptResponse, error)
+	Close() error
+}
+
 type client struct {
-	kmsClient   *kms.KeyManagementClient
+	kmsClient   kmsClient
 	ctx         context.Context
 	nameByKeyID map[string]string
+
+	// symmetricNames holds the fully-qualified CryptoKey resource names of
+	// symmetric keys registered via NewClient, keyed by themselves; unlike
+	// nameByKeyID, membership is checked directly against a stanza's key
+	// name since symmetric keys have no public key to derive an ID from.
+	symmetricNames map[string]bool
 }
 
 func parsePEMEncodedRSAPublicKey(pemBytes []byte) (*rsa.PublicKey, error) {
@@ -85,10 +98,13 @@ func parsePEMEncodedRSAPublicKey(pemBytes []byte) (*rsa.PublicKey, error) {
 	}
 }
 
-func (c *client) addKey(ctx context.Context, name string) error {
-	resp, err := c.kmsClient.GetPublicKey(ctx, &kmspb.GetPublicKeyRequest{Name: name})
+// fetchRSAPublicKey retrieves and validates the RSA-OAEP public key for
+// name, shared by addKey and the KeyManager's CreateRecipient. It returns
+// the resolved CryptoKeyVersion name alongside the key.
+func fetchRSAPublicKey(ctx context.Context, kmsClient kmsClient, name string) (*rsa.PublicKey, string, error) {
+	resp, err := kmsClient.GetPublicKey(ctx, &kmspb.GetPublicKeyRequest{Name: name})
 	if err != nil {
-		return err
+		return nil, "", err
 	}
 
 	switch resp.Algorithm {
@@ -96,18 +112,38 @@ func (c *client) addKey(ctx context.Context, name string) error {
 	case kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_3072_SHA256:
 	case kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_4096_SHA256:
 	default:
-		return fmt.Errorf("unsupported key type: %s", resp.Algorithm.String())
+		return nil, "", fmt.Errorf("unsupported key type: %s", resp.Algorithm.String())
 	}
 
 	key, err := parsePEMEncodedRSAPublicKey([]byte(resp.Pem))
+	if err != nil {
+		return nil, "", err
+	}
+	return key, resp.Name, nil
+}
+
+// isCryptoKeyVersionName reports whether name points at a specific
+// CryptoKeyVersion, as opposed to a CryptoKey (whose primary version KMS
+// selects automatically on every symmetric Encrypt/Decrypt call).
+func isCryptoKeyVersionName(name string) bool {
+	return strings.Contains(name, "/cryptoKeyVersions/")
+}
+
+func (c *client) addKey(ctx context.Context, name string) error {
+	if !isCryptoKeyVersionName(name) {
+		c.symmetricNames[name] = true
+		return nil
+	}
+
+	key, resolvedName, err := fetchRSAPublicKey(ctx, c.kmsClient, name)
 	if err != nil {
 		return err
 	}
-	id, err := keyID(key)
+	id, err := rsaoaep.KeyID(key)
 	if err != nil {
 		return err
 	}
-	c.nameByKeyID[id] = resp.Name
+	c.nameByKeyID[id] = resolvedName
 	return nil
 }
 
@@ -118,28 +154,77 @@ func crc32c(data []byte) uint32 {
 
 func (c *client) Unwrap(stanzas []*age.Stanza) (fileKey []byte, err error) {
 	for _, stanza := range stanzas {
-		if stanza.Type != "kms-rsa-oaep-sha256" {
-			continue
-		}
-		if len(stanza.Args) != 1 {
-			return nil, fmt.Errorf("invalid kms-rsa-oaep-sha256 recipient")
-		}
-
-		name, ok := c.nameByKeyID[stanza.Args[0]]
-		if !ok {
-			continue
-		}
-
-		req := &kmspb.AsymmetricDecryptRequest{
-			Name:             name,
-			Ciphertext:       stanza.Body,
-			CiphertextCrc32C: wrapperspb.Int64(int64(crc32c(stanza.Body))),
-		}
-		resp, err := c.kmsClient.AsymmetricDecrypt(c.ctx, req)
-		if err != nil {
-			return nil, err
+		switch stanza.Type {
+		case "kms-rsa-oaep-sha256":
+			if len(stanza.Args) != 1 {
+				return nil, fmt.Errorf("invalid kms-rsa-oaep-sha256 recipient")
+			}
+			name, ok := c.nameByKeyID[stanza.Args[0]]
+			if !ok {
+				continue
+			}
+
+			req := &kmspb.AsymmetricDecryptRequest{
+				Name:             name,
+				Ciphertext:       stanza.Body,
+				CiphertextCrc32C: wrapperspb.Int64(int64(crc32c(stanza.Body))),
+			}
+			resp, err := c.kmsClient.AsymmetricDecrypt(c.ctx, req)
+			if err != nil {
+				return nil, err
+			}
+
+			// Per Google's integrity guidance, confirm KMS received the
+			// ciphertext intact and that the plaintext it returned wasn't
+			// corrupted in transit before trusting it.
+			if !resp.VerifiedCiphertextCrc32C {
+				return nil, fmt.Errorf("kms: request CiphertextCrc32C was not verified by the server")
+			}
+			if int64(crc32c(resp.Plaintext)) != resp.PlaintextCrc32C.Value {
+				return nil, fmt.Errorf("kms: response corrupted in transit: plaintext checksum mismatch")
+			}
+
+			return resp.Plaintext, nil
+
+		case "kms-gcp-symmetric":
+			if len(stanza.Args) != 1 {
+				return nil, fmt.Errorf("invalid kms-gcp-symmetric recipient")
+			}
+			name := stanza.Args[0]
+			if !c.symmetricNames[name] {
+				continue
+			}
+
+			req := &kmspb.DecryptRequest{
+				Name:             name,
+				Ciphertext:       stanza.Body,
+				CiphertextCrc32C: wrapperspb.Int64(int64(crc32c(stanza.Body))),
+			}
+			resp, err := c.kmsClient.Decrypt(c.ctx, req)
+			if err != nil {
+				return nil, err
+			}
+
+			// DecryptResponse has no VerifiedCiphertextCrc32C field (unlike
+			// AsymmetricDecryptResponse); KMS already rejects a corrupted
+			// request ciphertext server-side, via the error above, so only
+			// the returned plaintext needs checking here.
+			if int64(crc32c(resp.Plaintext)) != resp.PlaintextCrc32C.Value {
+				return nil, fmt.Errorf("kms: response corrupted in transit: plaintext checksum mismatch")
+			}
+
+			return resp.Plaintext, nil
+
+		case "kms-envelope-aes256-gcm":
+			if len(stanza.Args) != 2 {
+				return nil, fmt.Errorf("invalid kms-envelope-aes256-gcm recipient")
+			}
+			name := stanza.Args[0]
+			if !c.symmetricNames[name] {
+				continue
+			}
+			return unwrapEnvelope(c.ctx, c.kmsClient, name, stanza.Args[1], stanza.Body)
 		}
-		return resp.Plaintext, nil
 	}
 	return nil, age.ErrIncorrectIdentity
 }
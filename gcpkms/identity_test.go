@@ -0,0 +1,239 @@
+// Copyright 2021 RetailNext, Inc. All rights reserved.
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gcpkms
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+
+	"filippo.io/age"
+	gax "github.com/googleapis/gax-go/v2"
+	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const testKeyName = "projects/p/locations/l/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1"
+const testSymmetricKeyName = "projects/p/locations/l/keyRings/r/cryptoKeys/k"
+
+var errTestDecrypt = errors.New("decrypt failed")
+
+// fakeKMSClient implements kmsClient for testing Unwrap's and Wrap's
+// response integrity checks without calling out to Google Cloud KMS.
+type fakeKMSClient struct {
+	pubKeyPEM   []byte
+	decryptResp *kmspb.AsymmetricDecryptResponse
+	decryptErr  error
+
+	encryptFn          func(req *kmspb.EncryptRequest) (*kmspb.EncryptResponse, error)
+	decryptSymmetricFn func(req *kmspb.DecryptRequest) (*kmspb.DecryptResponse, error)
+}
+
+func (f *fakeKMSClient) GetPublicKey(ctx context.Context, req *kmspb.GetPublicKeyRequest, opts ...gax.CallOption) (*kmspb.PublicKey, error) {
+	return &kmspb.PublicKey{
+		Name:      req.Name,
+		Pem:       string(f.pubKeyPEM),
+		Algorithm: kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_3072_SHA256,
+	}, nil
+}
+
+func (f *fakeKMSClient) AsymmetricDecrypt(ctx context.Context, req *kmspb.AsymmetricDecryptRequest, opts ...gax.CallOption) (*kmspb.AsymmetricDecryptResponse, error) {
+	return f.decryptResp, f.decryptErr
+}
+
+func (f *fakeKMSClient) Encrypt(ctx context.Context, req *kmspb.EncryptRequest, opts ...gax.CallOption) (*kmspb.EncryptResponse, error) {
+	if f.encryptFn == nil {
+		return nil, errors.New("Encrypt not configured on fakeKMSClient")
+	}
+	return f.encryptFn(req)
+}
+
+func (f *fakeKMSClient) Decrypt(ctx context.Context, req *kmspb.DecryptRequest, opts ...gax.CallOption) (*kmspb.DecryptResponse, error) {
+	if f.decryptSymmetricFn == nil {
+		return nil, errors.New("Decrypt not configured on fakeKMSClient")
+	}
+	return f.decryptSymmetricFn(req)
+}
+
+func (f *fakeKMSClient) Close() error { return nil }
+
+// fakeSymmetricKMS is an in-memory symmetric KMS: Encrypt "wraps" a
+// plaintext by prefixing it with a fixed tag, Decrypt strips it back off,
+// so tests can exercise real Wrap/Unwrap round trips through the fake.
+type fakeSymmetricKMS struct{ tag string }
+
+func (f *fakeSymmetricKMS) encrypt(req *kmspb.EncryptRequest) (*kmspb.EncryptResponse, error) {
+	ciphertext := append([]byte(f.tag), req.Plaintext...)
+	return &kmspb.EncryptResponse{
+		Ciphertext:              ciphertext,
+		CiphertextCrc32C:        wrapperspb.Int64(int64(crc32c(ciphertext))),
+		VerifiedPlaintextCrc32C: true,
+	}, nil
+}
+
+func (f *fakeSymmetricKMS) decrypt(req *kmspb.DecryptRequest) (*kmspb.DecryptResponse, error) {
+	if len(req.Ciphertext) < len(f.tag) || string(req.Ciphertext[:len(f.tag)]) != f.tag {
+		return nil, errors.New("fakeSymmetricKMS: ciphertext missing expected tag")
+	}
+	plaintext := req.Ciphertext[len(f.tag):]
+	return &kmspb.DecryptResponse{
+		Plaintext:       plaintext,
+		PlaintextCrc32C: wrapperspb.Int64(int64(crc32c(plaintext))),
+	}, nil
+}
+
+func newTestClient(t *testing.T, decryptResp *kmspb.AsymmetricDecryptResponse) (*client, *rsa.PrivateKey) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	keyBytes, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("marshal public key: %v", err)
+	}
+	pubKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: keyBytes})
+
+	fake := &fakeKMSClient{pubKeyPEM: pubKeyPEM, decryptResp: decryptResp}
+	cl := &client{kmsClient: fake, ctx: context.Background(), nameByKeyID: make(map[string]string)}
+	if err := cl.addKey(context.Background(), testKeyName); err != nil {
+		t.Fatalf("addKey: %v", err)
+	}
+	return cl, key
+}
+
+func stanzaFor(cl *client) *age.Stanza {
+	for id := range cl.nameByKeyID {
+		return &age.Stanza{Type: "kms-rsa-oaep-sha256", Args: []string{id}, Body: []byte("ciphertext")}
+	}
+	return nil
+}
+
+func TestUnwrapVerifiesCiphertextCrc32C(t *testing.T) {
+	plaintext := []byte("file key material")
+	resp := &kmspb.AsymmetricDecryptResponse{
+		Plaintext:                plaintext,
+		PlaintextCrc32C:          wrapperspb.Int64(int64(crc32c(plaintext))),
+		VerifiedCiphertextCrc32C: false,
+	}
+	cl, _ := newTestClient(t, resp)
+
+	if _, err := cl.Unwrap([]*age.Stanza{stanzaFor(cl)}); err == nil {
+		t.Fatal("expected error when VerifiedCiphertextCrc32C is false, got nil")
+	}
+}
+
+func TestUnwrapVerifiesPlaintextCrc32C(t *testing.T) {
+	plaintext := []byte("file key material")
+	corrupted := append([]byte(nil), plaintext...)
+	corrupted[0] ^= 0xff
+	resp := &kmspb.AsymmetricDecryptResponse{
+		Plaintext:                corrupted,
+		PlaintextCrc32C:          wrapperspb.Int64(int64(crc32c(plaintext))),
+		VerifiedCiphertextCrc32C: true,
+	}
+	cl, _ := newTestClient(t, resp)
+
+	if _, err := cl.Unwrap([]*age.Stanza{stanzaFor(cl)}); err == nil {
+		t.Fatal("expected error when PlaintextCrc32C doesn't match Plaintext, got nil")
+	}
+}
+
+func TestUnwrapSucceedsWithValidChecksums(t *testing.T) {
+	plaintext := []byte("file key material")
+	resp := &kmspb.AsymmetricDecryptResponse{
+		Plaintext:                plaintext,
+		PlaintextCrc32C:          wrapperspb.Int64(int64(crc32c(plaintext))),
+		VerifiedCiphertextCrc32C: true,
+	}
+	cl, _ := newTestClient(t, resp)
+
+	got, err := cl.Unwrap([]*age.Stanza{stanzaFor(cl)})
+	if err != nil {
+		t.Fatalf("Unwrap: %v", err)
+	}
+	if string(got) != string(plaintext) {
+		t.Fatalf("Unwrap returned %q, want %q", got, plaintext)
+	}
+}
+
+func TestUnwrapPropagatesDecryptError(t *testing.T) {
+	cl, _ := newTestClient(t, nil)
+	cl.kmsClient.(*fakeKMSClient).decryptErr = errTestDecrypt
+
+	if _, err := cl.Unwrap([]*age.Stanza{stanzaFor(cl)}); err != errTestDecrypt {
+		t.Fatalf("Unwrap error = %v, want %v", err, errTestDecrypt)
+	}
+}
+
+func newTestSymmetricClient(kc kmsClient) *client {
+	return &client{
+		kmsClient:      kc,
+		ctx:            context.Background(),
+		nameByKeyID:    make(map[string]string),
+		symmetricNames: map[string]bool{testSymmetricKeyName: true},
+	}
+}
+
+func TestSymmetricWrapUnwrapRoundTrip(t *testing.T) {
+	fake := &fakeSymmetricKMS{tag: "wrapped:"}
+	kc := &fakeKMSClient{encryptFn: fake.encrypt, decryptSymmetricFn: fake.decrypt}
+
+	recipient := &symmetricRecipient{kmsClient: kc, ctx: context.Background(), name: testSymmetricKeyName}
+	fileKey := []byte("file key material")
+	stanzas, err := recipient.Wrap(fileKey)
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+
+	cl := newTestSymmetricClient(kc)
+	got, err := cl.Unwrap(stanzas)
+	if err != nil {
+		t.Fatalf("Unwrap: %v", err)
+	}
+	if string(got) != string(fileKey) {
+		t.Fatalf("Unwrap returned %q, want %q", got, fileKey)
+	}
+}
+
+// TestUnwrapSymmetricDetectsPlaintextCrc32CMismatch is a regression test
+// for the symmetric Decrypt path: it must still catch a corrupted
+// response by comparing PlaintextCrc32C, now that it can no longer rely
+// on a VerifiedCiphertextCrc32C field (DecryptResponse doesn't have one).
+func TestUnwrapSymmetricDetectsPlaintextCrc32CMismatch(t *testing.T) {
+	kc := &fakeKMSClient{
+		decryptSymmetricFn: func(req *kmspb.DecryptRequest) (*kmspb.DecryptResponse, error) {
+			plaintext := []byte("file key material")
+			return &kmspb.DecryptResponse{
+				Plaintext:       plaintext,
+				PlaintextCrc32C: wrapperspb.Int64(int64(crc32c(plaintext)) + 1),
+			}, nil
+		},
+	}
+	cl := newTestSymmetricClient(kc)
+
+	stanza := &age.Stanza{Type: "kms-gcp-symmetric", Args: []string{testSymmetricKeyName}, Body: []byte("ciphertext")}
+	if _, err := cl.Unwrap([]*age.Stanza{stanza}); err == nil {
+		t.Fatal("expected error when PlaintextCrc32C doesn't match Plaintext, got nil")
+	}
+}
+
+func TestUnwrapSymmetricPropagatesDecryptError(t *testing.T) {
+	kc := &fakeKMSClient{decryptSymmetricFn: func(req *kmspb.DecryptRequest) (*kmspb.DecryptResponse, error) {
+		return nil, errTestDecrypt
+	}}
+	cl := newTestSymmetricClient(kc)
+
+	stanza := &age.Stanza{Type: "kms-gcp-symmetric", Args: []string{testSymmetricKeyName}, Body: []byte("ciphertext")}
+	if _, err := cl.Unwrap([]*age.Stanza{stanza}); err != errTestDecrypt {
+		t.Fatalf("Unwrap error = %v, want %v", err, errTestDecrypt)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2021 RetailNext, Inc. All rights reserved.
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gcpkms
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+)
+
+func TestKeyManagerCreateDecrypterReusesKeyManagersClient(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	keyBytes, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("marshal public key: %v", err)
+	}
+	pubKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: keyBytes})
+
+	fake := &fakeKMSClient{pubKeyPEM: pubKeyPEM}
+	m := &keyManager{kmsClient: fake, ctx: context.Background()}
+
+	identity, err := m.CreateDecrypter("gcpkms:" + testKeyName)
+	if err != nil {
+		t.Fatalf("CreateDecrypter: %v", err)
+	}
+	cl, ok := identity.(*client)
+	if !ok {
+		t.Fatalf("CreateDecrypter returned %T, want *client", identity)
+	}
+	if cl.kmsClient != fake {
+		t.Fatal("CreateDecrypter built a client around a different kmsClient than the KeyManager's own")
+	}
+}
+
+func TestKeyManagerCreateRecipientSymmetricKey(t *testing.T) {
+	fake := &fakeKMSClient{}
+	m := &keyManager{kmsClient: fake, ctx: context.Background()}
+
+	recipient, err := m.CreateRecipient("gcpkms:" + testSymmetricKeyName)
+	if err != nil {
+		t.Fatalf("CreateRecipient: %v", err)
+	}
+	r, ok := recipient.(*symmetricRecipient)
+	if !ok {
+		t.Fatalf("CreateRecipient returned %T, want *symmetricRecipient", recipient)
+	}
+	if r.kmsClient != fake {
+		t.Fatal("CreateRecipient built a symmetricRecipient around a different kmsClient than the KeyManager's own")
+	}
+}
+
+func TestEnvelopeKeyManagerCreateRecipientAndCreateDecrypterReuseClient(t *testing.T) {
+	fake := &fakeKMSClient{}
+	m := &envelopeKeyManager{kmsClient: fake, ctx: context.Background()}
+	keyURI := "gcpkms-envelope:" + testSymmetricKeyName
+
+	recipient, err := m.CreateRecipient(keyURI)
+	if err != nil {
+		t.Fatalf("CreateRecipient: %v", err)
+	}
+	er, ok := recipient.(*envelopeRecipient)
+	if !ok {
+		t.Fatalf("CreateRecipient returned %T, want *envelopeRecipient", recipient)
+	}
+	if er.kmsClient != fake {
+		t.Fatal("CreateRecipient built an envelopeRecipient around a different kmsClient than the KeyManager's own")
+	}
+
+	identity, err := m.CreateDecrypter(keyURI)
+	if err != nil {
+		t.Fatalf("CreateDecrypter: %v", err)
+	}
+	cl, ok := identity.(*client)
+	if !ok {
+		t.Fatalf("CreateDecrypter returned %T, want *client", identity)
+	}
+	if cl.kmsClient != fake {
+		t.Fatal("CreateDecrypter built a client around a different kmsClient than the KeyManager's own")
+	}
+	if !cl.symmetricNames[testSymmetricKeyName] {
+		t.Fatal("CreateDecrypter's client did not register the envelope key as symmetric")
+	}
+}
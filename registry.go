@@ -0,0 +1,56 @@
+// Copyright 2021 RetailNext, Inc. All rights reserved.
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package agekms
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// NewFunc constructs a KeyManager for one backend. Backends register one
+// of these under their URI scheme with Register, normally from an init
+// function.
+type NewFunc func(ctx context.Context) (KeyManager, error)
+
+var registry = make(map[string]NewFunc)
+
+// Register makes a KeyManager backend available under the given key URI
+// scheme, e.g. "gcpkms", "awskms", "azurekms", "softkms". It is meant to
+// be called from the init function of a backend package.
+func Register(scheme string, fn NewFunc) {
+	registry[scheme] = fn
+}
+
+// NewClient parses the scheme off keyURI and dispatches to the KeyManager
+// backend registered for it. keyURI is expected to look like
+// "gcpkms:projects/.../cryptoKeyVersions/1", "awskms:arn:aws:kms:...",
+// or "softkms:/path/to/key.pem". A scheme with no registered backend,
+// such as "azurekms" (no package in this module registers it), returns
+// an error.
+func NewClient(ctx context.Context, keyURI string) (KeyManager, error) {
+	scheme, _, err := SplitKeyURI(keyURI)
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := registry[scheme]
+	if !ok {
+		return nil, fmt.Errorf("agekms: unsupported key URI scheme %q", scheme)
+	}
+	return fn(ctx)
+}
+
+// SplitKeyURI splits a key URI of the form "<scheme>:<name>" into its
+// scheme and the backend-specific key name that follows it. Backends use
+// this in CreateDecrypter/CreateRecipient to strip their own scheme
+// prefix before looking up the key.
+func SplitKeyURI(keyURI string) (scheme, name string, err error) {
+	scheme, name, ok := strings.Cut(keyURI, ":")
+	if !ok {
+		return "", "", fmt.Errorf("agekms: invalid key URI %q: missing scheme", keyURI)
+	}
+	return scheme, name, nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2021 RetailNext, Inc. All rights reserved.
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package agekms
+
+import (
+	"context"
+	"testing"
+
+	"filippo.io/age"
+)
+
+type fakeKeyManager struct{ keyURI string }
+
+func (m *fakeKeyManager) CreateDecrypter(keyURI string) (age.Identity, error) {
+	m.keyURI = keyURI
+	return nil, nil
+}
+
+func (m *fakeKeyManager) CreateRecipient(keyURI string) (age.Recipient, error) {
+	m.keyURI = keyURI
+	return nil, nil
+}
+
+func TestSplitKeyURI(t *testing.T) {
+	scheme, name, err := SplitKeyURI("fakekms:projects/p/key")
+	if err != nil {
+		t.Fatalf("SplitKeyURI: %v", err)
+	}
+	if scheme != "fakekms" || name != "projects/p/key" {
+		t.Fatalf("SplitKeyURI = (%q, %q), want (%q, %q)", scheme, name, "fakekms", "projects/p/key")
+	}
+}
+
+func TestSplitKeyURIRejectsMissingScheme(t *testing.T) {
+	if _, _, err := SplitKeyURI("no-colon-here"); err == nil {
+		t.Fatal("expected error for key URI with no scheme, got nil")
+	}
+}
+
+func TestNewClientDispatchesToRegisteredScheme(t *testing.T) {
+	fake := &fakeKeyManager{}
+	Register("fakekms-dispatch", func(ctx context.Context) (KeyManager, error) {
+		return fake, nil
+	})
+
+	got, err := NewClient(context.Background(), "fakekms-dispatch:some-key")
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if got != fake {
+		t.Fatalf("NewClient returned %v, want the registered KeyManager", got)
+	}
+}
+
+func TestNewClientRejectsUnregisteredScheme(t *testing.T) {
+	if _, err := NewClient(context.Background(), "no-such-scheme:some-key"); err == nil {
+		t.Fatal("expected error for unregistered scheme, got nil")
+	}
+}
+
+func TestNewClientRejectsInvalidKeyURI(t *testing.T) {
+	if _, err := NewClient(context.Background(), "missing-a-colon"); err == nil {
+		t.Fatal("expected error for key URI with no scheme, got nil")
+	}
+}
@@ -25,7 +25,7 @@ import (
 // This implementation also uses a different stanza Type and a different
 // form of key identification.
 func NewRecipient(key *rsa.PublicKey) (age.Recipient, error) {
-	keyID, err := keyID(key)
+	keyID, err := KeyID(key)
 	if err != nil {
 		return nil, err
 	}
@@ -35,8 +35,13 @@ func NewRecipient(key *rsa.PublicKey) (age.Recipient, error) {
 	}, nil
 }
 
-func keyID(key *rsa.PublicKey) (string, error) {
-	keyBytes, err := asn1.Marshal(key)
+// KeyID derives the stanza key identifier for key: the base64-encoded
+// SHA256 digest of its ASN.1 encoding. Every backend that speaks the
+// "kms-rsa-oaep-sha256" stanza type must compute it this way so that a
+// single ciphertext can be decrypted by whichever backend holds the
+// matching RSA key.
+func KeyID(key *rsa.PublicKey) (string, error) {
+	keyBytes, err := asn1.Marshal(*key)
 	if err != nil {
 		return "", err
 	}
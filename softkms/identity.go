@@ -0,0 +1,118 @@
+// Copyright 2021 RetailNext, Inc. All rights reserved.
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package softkms
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"filippo.io/age"
+
+	"github.com/retailnext/agekms/rsaoaep"
+)
+
+// NewClient creates an age.Identity that decrypts locally using the given
+// RSA private keys. This mirrors gcpkms.Client and awskms.Client so that
+// a ciphertext produced against a cloud-hosted key can also be decrypted
+// offline, which is useful for unit tests, CI, and disaster recovery.
+func NewClient(keys ...*rsa.PrivateKey) (Client, error) {
+	cl := client{keyByKeyID: make(map[string]*rsa.PrivateKey, len(keys))}
+	for _, key := range keys {
+		if err := cl.addKey(key); err != nil {
+			return nil, err
+		}
+	}
+	return &cl, nil
+}
+
+// NewClientFromFiles is like NewClient but reads PEM-encoded RSA private
+// keys from the given paths.
+func NewClientFromFiles(paths ...string) (Client, error) {
+	keys := make([]*rsa.PrivateKey, 0, len(paths))
+	for _, path := range paths {
+		key, err := readPEMEncodedRSAPrivateKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("problem with key %q: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	return NewClient(keys...)
+}
+
+// Client is an age.Identity; it holds no resources that need closing, but
+// implements Close to stay interchangeable with the other agekms backends.
+type Client interface {
+	Unwrap(stanzas []*age.Stanza) (fileKey []byte, err error)
+	Close() error
+}
+
+type client struct {
+	keyByKeyID map[string]*rsa.PrivateKey
+}
+
+func readPEMEncodedRSAPrivateKey(path string) (*rsa.PrivateKey, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM blocks found")
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := parsed.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("unexpected private key type %T", parsed)
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("found unexpected %q PEM block", block.Type)
+	}
+}
+
+func (c *client) addKey(key *rsa.PrivateKey) error {
+	id, err := rsaoaep.KeyID(&key.PublicKey)
+	if err != nil {
+		return err
+	}
+	c.keyByKeyID[id] = key
+	return nil
+}
+
+func (c *client) Unwrap(stanzas []*age.Stanza) (fileKey []byte, err error) {
+	for _, stanza := range stanzas {
+		if stanza.Type != "kms-rsa-oaep-sha256" {
+			continue
+		}
+		if len(stanza.Args) != 1 {
+			return nil, fmt.Errorf("invalid kms-rsa-oaep-sha256 recipient")
+		}
+
+		key, ok := c.keyByKeyID[stanza.Args[0]]
+		if !ok {
+			continue
+		}
+
+		return rsa.DecryptOAEP(sha256.New(), rand.Reader, key, stanza.Body, nil)
+	}
+	return nil, age.ErrIncorrectIdentity
+}
+
+func (c *client) Close() error {
+	return nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2021 RetailNext, Inc. All rights reserved.
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package softkms
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/retailnext/agekms/rsaoaep"
+)
+
+func generateTestKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return key
+}
+
+func TestClientUnwrapRoundTrip(t *testing.T) {
+	key := generateTestKey(t)
+
+	recipient, err := rsaoaep.NewRecipient(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("NewRecipient: %v", err)
+	}
+	fileKey := []byte("0123456789abcdef")
+	stanzas, err := recipient.Wrap(fileKey)
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+
+	cl, err := NewClient(key)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	got, err := cl.Unwrap(stanzas)
+	if err != nil {
+		t.Fatalf("Unwrap: %v", err)
+	}
+	if string(got) != string(fileKey) {
+		t.Fatalf("Unwrap returned %q, want %q", got, fileKey)
+	}
+}
+
+func TestClientUnwrapUnknownKey(t *testing.T) {
+	key := generateTestKey(t)
+	other := generateTestKey(t)
+
+	recipient, err := rsaoaep.NewRecipient(&other.PublicKey)
+	if err != nil {
+		t.Fatalf("NewRecipient: %v", err)
+	}
+	stanzas, err := recipient.Wrap([]byte("file key"))
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+
+	cl, err := NewClient(key)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	if _, err := cl.Unwrap(stanzas); err != age.ErrIncorrectIdentity {
+		t.Fatalf("Unwrap error = %v, want age.ErrIncorrectIdentity", err)
+	}
+}
+
+func TestNewClientFromFilesPKCS1(t *testing.T) {
+	key := generateTestKey(t)
+	path := filepath.Join(t.TempDir(), "key.pem")
+	writePEM(t, path, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))
+
+	cl, err := NewClientFromFiles(path)
+	if err != nil {
+		t.Fatalf("NewClientFromFiles: %v", err)
+	}
+
+	recipient, err := rsaoaep.NewRecipient(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("NewRecipient: %v", err)
+	}
+	fileKey := []byte("file key material")
+	stanzas, err := recipient.Wrap(fileKey)
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+	got, err := cl.Unwrap(stanzas)
+	if err != nil {
+		t.Fatalf("Unwrap: %v", err)
+	}
+	if string(got) != string(fileKey) {
+		t.Fatalf("Unwrap returned %q, want %q", got, fileKey)
+	}
+}
+
+func TestNewClientFromFilesPKCS8(t *testing.T) {
+	key := generateTestKey(t)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "key.pem")
+	writePEM(t, path, "PRIVATE KEY", pkcs8)
+
+	cl, err := NewClientFromFiles(path)
+	if err != nil {
+		t.Fatalf("NewClientFromFiles: %v", err)
+	}
+
+	recipient, err := rsaoaep.NewRecipient(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("NewRecipient: %v", err)
+	}
+	fileKey := []byte("file key material")
+	stanzas, err := recipient.Wrap(fileKey)
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+	got, err := cl.Unwrap(stanzas)
+	if err != nil {
+		t.Fatalf("Unwrap: %v", err)
+	}
+	if string(got) != string(fileKey) {
+		t.Fatalf("Unwrap returned %q, want %q", got, fileKey)
+	}
+}
+
+func TestNewClientFromFilesUnsupportedPEMType(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key.pem")
+	writePEM(t, path, "CERTIFICATE", []byte("not a key"))
+
+	if _, err := NewClientFromFiles(path); err == nil {
+		t.Fatal("expected error for unsupported PEM block type, got nil")
+	}
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, pemBytes, 0o600); err != nil {
+		t.Fatalf("writing %s: %v", path, err)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2021 RetailNext, Inc. All rights reserved.
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package softkms
+
+import (
+	"context"
+	"crypto/rsa"
+	"sync"
+
+	"filippo.io/age"
+
+	"github.com/retailnext/agekms"
+	"github.com/retailnext/agekms/rsaoaep"
+)
+
+func init() {
+	agekms.Register("softkms", newKeyManager)
+}
+
+// keyManager implements agekms.KeyManager for key URIs of the form
+// "softkms:/path/to/key.pem". It caches each PEM file's parsed key by
+// path so that repeated CreateDecrypter/CreateRecipient calls for the
+// same key URI don't re-read and re-parse the file every time.
+type keyManager struct {
+	mu   sync.Mutex
+	keys map[string]*rsa.PrivateKey
+}
+
+func newKeyManager(ctx context.Context) (agekms.KeyManager, error) {
+	return &keyManager{keys: make(map[string]*rsa.PrivateKey)}, nil
+}
+
+func (m *keyManager) key(path string) (*rsa.PrivateKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if key, ok := m.keys[path]; ok {
+		return key, nil
+	}
+	key, err := readPEMEncodedRSAPrivateKey(path)
+	if err != nil {
+		return nil, err
+	}
+	m.keys[path] = key
+	return key, nil
+}
+
+func (m *keyManager) CreateDecrypter(keyURI string) (age.Identity, error) {
+	_, path, err := agekms.SplitKeyURI(keyURI)
+	if err != nil {
+		return nil, err
+	}
+	key, err := m.key(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(key)
+}
+
+func (m *keyManager) CreateRecipient(keyURI string) (age.Recipient, error) {
+	_, path, err := agekms.SplitKeyURI(keyURI)
+	if err != nil {
+		return nil, err
+	}
+	key, err := m.key(path)
+	if err != nil {
+		return nil, err
+	}
+	return rsaoaep.NewRecipient(&key.PublicKey)
+}
@@ -0,0 +1,120 @@
+// Copyright 2021 RetailNext, Inc. All rights reserved.
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package softkms
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"path/filepath"
+	"testing"
+
+	"github.com/retailnext/agekms"
+)
+
+func TestKeyManagerCreateDecrypterAndCreateRecipientRoundTrip(t *testing.T) {
+	key := generateTestKey(t)
+	path := filepath.Join(t.TempDir(), "key.pem")
+	writePEM(t, path, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))
+	keyURI := "softkms:" + path
+
+	m, err := newKeyManager(nil)
+	if err != nil {
+		t.Fatalf("newKeyManager: %v", err)
+	}
+
+	recipient, err := m.CreateRecipient(keyURI)
+	if err != nil {
+		t.Fatalf("CreateRecipient: %v", err)
+	}
+	fileKey := []byte("file key material")
+	stanzas, err := recipient.Wrap(fileKey)
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+
+	identity, err := m.CreateDecrypter(keyURI)
+	if err != nil {
+		t.Fatalf("CreateDecrypter: %v", err)
+	}
+	got, err := identity.Unwrap(stanzas)
+	if err != nil {
+		t.Fatalf("Unwrap: %v", err)
+	}
+	if string(got) != string(fileKey) {
+		t.Fatalf("Unwrap returned %q, want %q", got, fileKey)
+	}
+}
+
+func TestKeyManagerCachesParsedKeyByPath(t *testing.T) {
+	key := generateTestKey(t)
+	path := filepath.Join(t.TempDir(), "key.pem")
+	writePEM(t, path, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))
+
+	m := &keyManager{keys: make(map[string]*rsa.PrivateKey)}
+	first, err := m.key(path)
+	if err != nil {
+		t.Fatalf("key: %v", err)
+	}
+
+	// Overwrite the file with a different key; a cache hit should still
+	// return the key originally parsed rather than re-reading the file.
+	other := generateTestKey(t)
+	writePEM(t, path, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(other))
+
+	second, err := m.key(path)
+	if err != nil {
+		t.Fatalf("key: %v", err)
+	}
+	if second != first {
+		t.Fatal("key did not return the cached key on the second call")
+	}
+	if second.Equal(other) {
+		t.Fatal("key returned the freshly-written key instead of the cached one")
+	}
+}
+
+func TestKeyManagerViaRegistry(t *testing.T) {
+	key := generateTestKey(t)
+	path := filepath.Join(t.TempDir(), "key.pem")
+	writePEM(t, path, "PRIVATE KEY", mustMarshalPKCS8(t, key))
+	keyURI := "softkms:" + path
+
+	m, err := agekms.NewClient(nil, keyURI)
+	if err != nil {
+		t.Fatalf("agekms.NewClient: %v", err)
+	}
+
+	recipient, err := m.CreateRecipient(keyURI)
+	if err != nil {
+		t.Fatalf("CreateRecipient: %v", err)
+	}
+	fileKey := []byte("file key material")
+	stanzas, err := recipient.Wrap(fileKey)
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+
+	identity, err := m.CreateDecrypter(keyURI)
+	if err != nil {
+		t.Fatalf("CreateDecrypter: %v", err)
+	}
+	got, err := identity.Unwrap(stanzas)
+	if err != nil {
+		t.Fatalf("Unwrap: %v", err)
+	}
+	if string(got) != string(fileKey) {
+		t.Fatalf("Unwrap returned %q, want %q", got, fileKey)
+	}
+}
+
+func mustMarshalPKCS8(t *testing.T, key any) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey: %v", err)
+	}
+	return der
+}